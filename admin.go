@@ -0,0 +1,62 @@
+package proxy
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// upstreamStatus is the JSON view of a single upstream served by the
+// /proxy/admin/pool endpoint.
+type upstreamStatus struct {
+	Subdomain string `json:"subdomain"`
+	Port      int    `json:"port"`
+	Location  string `json:"location"`
+	IPAddress string `json:"ipaddress"`
+	Healthy   bool   `json:"healthy"`
+	Alive     bool   `json:"alive"`
+	InFlight  int64  `json:"in_flight"`
+}
+
+// PoolStatusHandler returns an http.Handler exposing the current upstream
+// pool (subdomain, health, in-flight counts) as JSON, meant to be mounted
+// alongside /proxy/healthz, e.g. at /proxy/admin/pool.
+func (r *ReverseProxy) PoolStatusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		statuses := r.poolStatus()
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(statuses); err != nil {
+			slog.Error(f("failed to encode pool status: %s", err))
+		}
+	})
+}
+
+func (r *ReverseProxy) poolStatus() []upstreamStatus {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var out []upstreamStatus
+	for subdomain, ph := range r.domainMap {
+		for port, pr := range ph {
+			for _, b := range pr.locations {
+				for _, h := range b.handlers {
+					h.mu.Lock()
+					healthy := h.healthy && time.Now().After(h.backoffUntil)
+					h.mu.Unlock()
+					out = append(out, upstreamStatus{
+						Subdomain: subdomain,
+						Port:      port,
+						Location:  b.prefix,
+						IPAddress: h.ipaddress,
+						Healthy:   healthy,
+						Alive:     h.alive(),
+						InFlight:  atomic.LoadInt64(&h.inFlight),
+					})
+				}
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,239 @@
+package proxy
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+)
+
+// AdminAPI exposes an authenticated HTTP surface for driving ReverseProxy's
+// Register/Deregister logic (the same path ProxyControl already models) and
+// for observing the current upstream pool, meant to be mounted under
+// /proxy/admin/ alongside the existing /proxy/healthz endpoint.
+type AdminAPI struct {
+	proxy *ReverseProxy
+	cfg   *Config
+
+	mu   sync.Mutex
+	subs map[chan adminEvent]struct{}
+}
+
+// NewAdminAPI builds an AdminAPI backed by proxy, authenticated per cfg
+// (bearer token and/or mTLS, see Handler).
+func NewAdminAPI(proxy *ReverseProxy, cfg *Config) *AdminAPI {
+	return &AdminAPI{
+		proxy: proxy,
+		cfg:   cfg,
+		subs:  make(map[chan adminEvent]struct{}),
+	}
+}
+
+// adminEvent is emitted on the SSE stream whenever an upstream is
+// registered or removed through the admin API.
+type adminEvent struct {
+	Type      string `json:"type"` // "added" or "removed"
+	Subdomain string `json:"subdomain"`
+	IPAddress string `json:"ipaddress"`
+	Port      int    `json:"port"`
+	Location  string `json:"location"`
+}
+
+// Handler returns the admin mux, with every route gated by authenticate.
+// Routes:
+//
+//	GET    /proxy/admin/upstreams  - list subdomains and their upstreams
+//	POST   /proxy/admin/upstreams  - register {subdomain,ipaddress,port,location,kind}
+//	DELETE /proxy/admin/upstreams  - remove {subdomain,ipaddress,port,location}
+//	GET    /proxy/admin/events     - Server-Sent Events stream of adminEvent
+func (a *AdminAPI) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/proxy/admin/upstreams", a.handleUpstreams)
+	mux.HandleFunc("/proxy/admin/events", a.handleEvents)
+	return a.authenticate(mux)
+}
+
+// authenticate accepts the request if it either presents a valid bearer
+// token (cfg.AdminBearerToken) or, when cfg.AdminRequireMTLS is set,
+// completed a mutual-TLS handshake with a client certificate. If neither is
+// configured, every request is rejected: the admin API refuses to run wide
+// open.
+func (a *AdminAPI) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if a.cfg.AdminRequireMTLS && req.TLS != nil && len(req.TLS.PeerCertificates) > 0 {
+			next.ServeHTTP(w, req)
+			return
+		}
+		if a.cfg.AdminBearerToken != "" && bearerTokenMatches(req, a.cfg.AdminBearerToken) {
+			next.ServeHTTP(w, req)
+			return
+		}
+		http.Error(w, "Forbidden", http.StatusForbidden)
+	})
+}
+
+func bearerTokenMatches(req *http.Request, want string) bool {
+	const prefix = "Bearer "
+	auth := req.Header.Get("Authorization")
+	if len(auth) <= len(prefix) || auth[:len(prefix)] != prefix {
+		return false
+	}
+	got := auth[len(prefix):]
+	return subtle.ConstantTimeCompare([]byte(got), []byte(want)) == 1
+}
+
+type upstreamRequest struct {
+	Subdomain string `json:"subdomain"`
+	IPAddress string `json:"ipaddress"`
+	Port      int    `json:"port"`
+	Location  string `json:"location,omitempty"`
+	Kind      string `json:"kind,omitempty"`
+}
+
+func (a *AdminAPI) handleUpstreams(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(a.proxy.poolStatus())
+	case http.MethodPost:
+		a.register(w, req)
+	case http.MethodDelete:
+		a.deregister(w, req)
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (a *AdminAPI) register(w http.ResponseWriter, req *http.Request) {
+	var ur upstreamRequest
+	if err := json.NewDecoder(req.Body).Decode(&ur); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request body: %s", err), http.StatusBadRequest)
+		return
+	}
+	ctrl := ProxyControl{
+		Action:    ProxyAdd,
+		Subdomain: ur.Subdomain,
+		IPAddress: ur.IPAddress,
+		Port:      ur.Port,
+		Location:  ur.Location,
+		Kind:      ur.Kind,
+	}
+	rt := newUpstreamRoundTripper(a.cfg, ctrl, "", "")
+	handler := &httpUpstreamHandler{addr: ur.IPAddress, transport: rt}
+	a.proxy.Register(ctrl, handler)
+	a.publish(adminEvent{Type: "added", Subdomain: ur.Subdomain, IPAddress: ur.IPAddress, Port: ur.Port, Location: ur.Location})
+	slog.Info(f("admin: registered %s -> %s:%d", ur.Subdomain, ur.IPAddress, ur.Port))
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (a *AdminAPI) deregister(w http.ResponseWriter, req *http.Request) {
+	var ur upstreamRequest
+	if err := json.NewDecoder(req.Body).Decode(&ur); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request body: %s", err), http.StatusBadRequest)
+		return
+	}
+	ctrl := ProxyControl{
+		Action:    ProxyRemove,
+		Subdomain: ur.Subdomain,
+		IPAddress: ur.IPAddress,
+		Port:      ur.Port,
+		Location:  ur.Location,
+	}
+	a.proxy.Deregister(ctrl)
+	a.publish(adminEvent{Type: "removed", Subdomain: ur.Subdomain, IPAddress: ur.IPAddress, Port: ur.Port, Location: ur.Location})
+	slog.Info(f("admin: removed %s -> %s:%d", ur.Subdomain, ur.IPAddress, ur.Port))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleEvents streams adminEvent as Server-Sent Events so operators/tools
+// can observe registrations in real time.
+func (a *AdminAPI) handleEvents(w http.ResponseWriter, req *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	ch := make(chan adminEvent, 16)
+	a.mu.Lock()
+	a.subs[ch] = struct{}{}
+	a.mu.Unlock()
+	defer func() {
+		a.mu.Lock()
+		delete(a.subs, ch)
+		a.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case ev := <-ch:
+			data, err := json.Marshal(ev)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			flusher.Flush()
+		}
+	}
+}
+
+func (a *AdminAPI) publish(ev adminEvent) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for ch := range a.subs {
+		select {
+		case ch <- ev:
+		default:
+			// slow subscriber, drop rather than block registration.
+		}
+	}
+}
+
+// httpUpstreamHandler proxies to the upstream at addr using transport, for
+// upstreams registered through the admin API; transport is the one
+// newUpstreamRoundTripper picks for the registered kind (HTTP or FastCGI).
+type httpUpstreamHandler struct {
+	addr      string
+	transport http.RoundTripper
+}
+
+func (h *httpUpstreamHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	outReq := req.Clone(req.Context())
+	outReq.URL.Scheme = "http"
+	outReq.URL.Host = h.addr
+	outReq.RequestURI = ""
+
+	resp, err := h.transport.RoundTrip(outReq)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("upstream error: %s", err), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	for k, vs := range resp.Header {
+		for _, v := range vs {
+			w.Header().Add(k, v)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	buf := make([]byte, 32*1024)
+	for {
+		n, rerr := resp.Body.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return
+			}
+		}
+		if rerr != nil {
+			return
+		}
+	}
+}
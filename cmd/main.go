@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"os"
 
+	feature "github.com/miseyu/feature-proxy"
 	"github.com/miseyu/feature-proxy/pkg"
 )
 
@@ -16,13 +17,21 @@ func main() {
 	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo}))
 	slog.SetDefault(logger)
 
+	proxyCfg := feature.GetConfig()
+	reverseProxy := feature.NewReverseProxy(proxyCfg)
+	adminAPI := feature.NewAdminAPI(reverseProxy, proxyCfg)
+
 	http.Handle("/proxy/healthz", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		_, _ = w.Write([]byte(`{"status":"ok"}`))
 		w.WriteHeader(http.StatusOK)
 	}))
-	proxy := pkg.NewReverseProxy(cfg.OriginScheme, cfg.OriginBaseDomain, cfg.DefaultSubDomain, cfg.FeatureHeader, cfg.OriginPort)
-	http.Handle("/", proxy)
+	http.Handle("/proxy/admin/pool", reverseProxy.PoolStatusHandler())
+	http.Handle("/proxy/admin/", adminAPI.Handler())
+	http.Handle("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reverseProxy.ServeHTTPWithPort(w, r, cfg.OriginPort)
+	}))
+
 	listenHost := fmt.Sprintf(":%v", port)
 	slog.Info("Listen on", "host", listenHost)
 	err := http.ListenAndServe(listenHost, nil)
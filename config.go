@@ -1,6 +1,8 @@
 package proxy
 
 import (
+	"time"
+
 	"github.com/kelseyhightower/envconfig"
 )
 
@@ -9,6 +11,37 @@ var c *Config
 type Config struct {
 	OriginDomain string `envconfig:"ORIGIN_DOMAIN"`
 	OriginScheme string `envconfig:"ORIGIN_SCHEME" default:"http"`
+
+	// LBPolicy selects the LoadBalancer used to distribute requests across
+	// upstreams registered for the same (subdomain, port): one of
+	// "round-robin", "least-conn", "ip-hash" or "random".
+	LBPolicy string `envconfig:"LB_POLICY" default:"round-robin"`
+
+	// HealthCheckPath, when set, enables the active health-check subsystem:
+	// each upstream is probed on this path every HealthCheckInterval and
+	// evicted from rotation while unhealthy.
+	HealthCheckPath     string        `envconfig:"HEALTH_CHECK_PATH"`
+	HealthCheckInterval time.Duration `envconfig:"HEALTH_CHECK_INTERVAL" default:"10s"`
+
+	// FastProxy selects the pooled-raw-connection HTTP/1.1 transport
+	// (pkg/proxy.FastTransport) over the default net/http transport for
+	// HTTP upstreams.
+	FastProxy bool `envconfig:"FAST_PROXY" default:"false"`
+
+	// AdminBearerToken, when set, is the bearer token required to call the
+	// /proxy/admin/ API (see AdminAPI).
+	AdminBearerToken string `envconfig:"ADMIN_BEARER_TOKEN"`
+	// AdminRequireMTLS accepts a client-certificate handshake as an
+	// alternative to AdminBearerToken for the /proxy/admin/ API.
+	AdminRequireMTLS bool `envconfig:"ADMIN_REQUIRE_MTLS" default:"false"`
+}
+
+func (cfg *Config) lbPolicy() LBPolicy {
+	return LBPolicy(cfg.LBPolicy)
+}
+
+func (cfg *Config) healthCheckInterval() time.Duration {
+	return cfg.HealthCheckInterval
 }
 
 func init() {
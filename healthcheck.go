@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const defaultHealthCheckInterval = 10 * time.Second
+
+// healthChecker periodically probes every registered upstream on a fixed
+// path and evicts unhealthy ones from load-balancing rotation. It is
+// independent of proxyHandlerLifetime, which only tracks idleness.
+type healthChecker struct {
+	proxy    *ReverseProxy
+	interval time.Duration
+	path     string
+	client   *http.Client
+
+	stop chan struct{}
+}
+
+func newHealthChecker(p *ReverseProxy, interval time.Duration, path string) *healthChecker {
+	if interval <= 0 {
+		interval = defaultHealthCheckInterval
+	}
+	return &healthChecker{
+		proxy:    p,
+		interval: interval,
+		path:     path,
+		client:   &http.Client{Timeout: 2 * time.Second},
+		stop:     make(chan struct{}),
+	}
+}
+
+func (hc *healthChecker) Start() {
+	go hc.run()
+}
+
+func (hc *healthChecker) Stop() {
+	close(hc.stop)
+}
+
+func (hc *healthChecker) run() {
+	ticker := time.NewTicker(hc.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-hc.stop:
+			return
+		case <-ticker.C:
+			hc.probeAll()
+		}
+	}
+}
+
+func (hc *healthChecker) probeAll() {
+	for _, h := range hc.proxy.handlers() {
+		h := h
+		go hc.probe(h)
+	}
+}
+
+// httpReachable reports whether kind is actually dialable with a plain HTTP
+// GET against ipaddress; anything else (a FastCGI socket, a virtual
+// ssh-tunnel identifier) is left alone rather than probed into eviction.
+func httpReachable(kind string) bool {
+	return kind == "" || kind == "http"
+}
+
+func (hc *healthChecker) probe(h *proxyHandler) {
+	if !httpReachable(h.kind) {
+		return
+	}
+	url := "http://" + h.ipaddress + hc.path
+	resp, err := hc.client.Get(url)
+	if err != nil {
+		slog.Info(f("health check failed for %s: %s", h.ipaddress, err))
+		h.setHealthy(false)
+		return
+	}
+	defer resp.Body.Close()
+	healthy := resp.StatusCode >= 200 && resp.StatusCode < 300
+	if !healthy {
+		slog.Info(f("health check failed for %s: status %d", h.ipaddress, resp.StatusCode))
+	}
+	h.setHealthy(healthy)
+}
+
+// handlers returns every proxyHandler currently registered across all
+// subdomains and ports, for use by the health checker.
+func (r *ReverseProxy) handlers() []*proxyHandler {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var out []*proxyHandler
+	for _, ph := range r.domainMap {
+		out = append(out, ph.all()...)
+	}
+	return out
+}
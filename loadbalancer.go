@@ -0,0 +1,109 @@
+package proxy
+
+import (
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+)
+
+// LBPolicy selects how traffic is distributed across the upstreams
+// registered for a given (subdomain, port) pair.
+type LBPolicy string
+
+const (
+	LBRoundRobin  = LBPolicy("round-robin")
+	LBLeastConn   = LBPolicy("least-conn")
+	LBIPHash      = LBPolicy("ip-hash")
+	LBRandom      = LBPolicy("random")
+	defaultLBPolicy = LBRoundRobin
+)
+
+// LoadBalancer picks one of the alive candidates for a request. clientIP is
+// used by policies that need request affinity (e.g. ip-hash); it is ignored
+// by the others.
+type LoadBalancer interface {
+	Pick(clientIP string, candidates []*proxyHandler) *proxyHandler
+}
+
+// NewLoadBalancer returns the LoadBalancer implementation for policy,
+// falling back to defaultLBPolicy for unrecognised values.
+func NewLoadBalancer(policy LBPolicy) LoadBalancer {
+	switch policy {
+	case LBLeastConn:
+		return &leastConnLB{}
+	case LBIPHash:
+		return &ipHashLB{}
+	case LBRandom:
+		return &randomLB{}
+	case LBRoundRobin:
+		return &roundRobinLB{}
+	default:
+		return &roundRobinLB{}
+	}
+}
+
+// roundRobinLB cycles through candidates in the order they are given.
+// Callers are expected to pass candidates in a stable order (proxyHandlers.pick
+// sorts by ipaddress) so this rotates rather than just avoiding Go's
+// randomized map iteration. One counter is shared by every pool the
+// LoadBalancer picks for, so picks for other pools in between advance a
+// pool's rotation too.
+type roundRobinLB struct {
+	mu  sync.Mutex
+	ctr uint64
+}
+
+func (lb *roundRobinLB) Pick(_ string, candidates []*proxyHandler) *proxyHandler {
+	if len(candidates) == 0 {
+		return nil
+	}
+	lb.mu.Lock()
+	i := lb.ctr % uint64(len(candidates))
+	lb.ctr++
+	lb.mu.Unlock()
+	return candidates[i]
+}
+
+// leastConnLB sends the request to the upstream with the fewest in-flight
+// requests.
+type leastConnLB struct{}
+
+func (lb *leastConnLB) Pick(_ string, candidates []*proxyHandler) *proxyHandler {
+	var best *proxyHandler
+	var bestInFlight int64 = -1
+	for _, h := range candidates {
+		inFlight := atomic.LoadInt64(&h.inFlight)
+		if bestInFlight == -1 || inFlight < bestInFlight {
+			best = h
+			bestInFlight = inFlight
+		}
+	}
+	return best
+}
+
+// ipHashLB sticks a client IP to the same upstream as long as the set of
+// candidates doesn't change.
+type ipHashLB struct{}
+
+func (lb *ipHashLB) Pick(clientIP string, candidates []*proxyHandler) *proxyHandler {
+	if len(candidates) == 0 {
+		return nil
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(clientIP))
+	return candidates[h.Sum32()%uint32(len(candidates))]
+}
+
+// randomLB picks a candidate using a counter-based pseudo-random index,
+// avoiding a dependency on math/rand for a single call site.
+type randomLB struct {
+	ctr uint64
+}
+
+func (lb *randomLB) Pick(_ string, candidates []*proxyHandler) *proxyHandler {
+	if len(candidates) == 0 {
+		return nil
+	}
+	n := atomic.AddUint64(&lb.ctr, 0x9E3779B97F4A7C15)
+	return candidates[n%uint64(len(candidates))]
+}
@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"sort"
+	"strings"
+)
+
+// defaultLocation is the fallback location every port implicitly serves
+// unless a more specific prefix matches.
+const defaultLocation = "/"
+
+// locationBucket holds every upstream registered for a single (port,
+// location) pair.
+type locationBucket struct {
+	prefix   string
+	handlers map[string]*proxyHandler // ipaddress -> handler
+}
+
+// portRoutes holds the location buckets registered for a single port,
+// ordered longest-prefix-first so the first match wins.
+type portRoutes struct {
+	locations []*locationBucket
+}
+
+func newPortRoutes() *portRoutes {
+	return &portRoutes{}
+}
+
+// normalizeLocation ensures a registered location always ends in "/", so
+// prefix matching can only land on a path-segment boundary: "/api" and
+// "/api/" both normalize to "/api/", which matches "/api" and "/api/foo"
+// but not "/apikey".
+func normalizeLocation(location string) string {
+	if location == "" {
+		return defaultLocation
+	}
+	if !strings.HasSuffix(location, "/") {
+		return location + "/"
+	}
+	return location
+}
+
+// bucket returns the bucket for prefix, creating it (and re-sorting) if it
+// doesn't exist yet. prefix is normalized via normalizeLocation.
+func (pr *portRoutes) bucket(prefix string) *locationBucket {
+	prefix = normalizeLocation(prefix)
+	for _, b := range pr.locations {
+		if b.prefix == prefix {
+			return b
+		}
+	}
+	b := &locationBucket{prefix: prefix, handlers: make(map[string]*proxyHandler)}
+	pr.locations = append(pr.locations, b)
+	sort.Slice(pr.locations, func(i, j int) bool {
+		return len(pr.locations[i].prefix) > len(pr.locations[j].prefix)
+	})
+	return b
+}
+
+// match returns the longest registered location that urlPath falls under,
+// or nil if none match. Since every registered prefix ends in "/", a match
+// requires either an exact hit on the location without its trailing slash
+// (e.g. "/api" against "/api/") or urlPath to continue past the slash
+// (e.g. "/api/v2") — never a raw byte prefix like "/apikey".
+func (pr *portRoutes) match(urlPath string) *locationBucket {
+	for _, b := range pr.locations {
+		if urlPath == strings.TrimSuffix(b.prefix, "/") || strings.HasPrefix(urlPath, b.prefix) {
+			return b
+		}
+	}
+	return nil
+}
+
+// prune drops empty buckets, e.g. after the last upstream behind a location
+// is removed.
+func (pr *portRoutes) prune() {
+	kept := pr.locations[:0]
+	for _, b := range pr.locations {
+		if len(b.handlers) > 0 {
+			kept = append(kept, b)
+		}
+	}
+	pr.locations = kept
+}
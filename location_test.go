@@ -0,0 +1,66 @@
+package proxy
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestPortRoutesMatchLongestPrefixWins(t *testing.T) {
+	pr := newPortRoutes()
+	pr.bucket("/api")
+	pr.bucket("/api/v2")
+	pr.bucket("/")
+
+	cases := []struct {
+		path string
+		want string
+	}{
+		{"/api/v2/users", "/api/v2/"},
+		{"/api/v1/users", "/api/"},
+		{"/api", "/api/"},
+		{"/apikey", "/"},
+		{"/", "/"},
+		{"/other", "/"},
+	}
+	for _, c := range cases {
+		b := pr.match(c.path)
+		if b == nil {
+			t.Errorf("match(%q) = nil, want prefix %q", c.path, c.want)
+			continue
+		}
+		if b.prefix != c.want {
+			t.Errorf("match(%q) = %q, want %q", c.path, b.prefix, c.want)
+		}
+	}
+}
+
+func TestPortRoutesMatchNoFallbackRegistered(t *testing.T) {
+	pr := newPortRoutes()
+	pr.bucket("/api")
+
+	if b := pr.match("/other"); b != nil {
+		t.Errorf("match(%q) = %q, want nil (no \"/\" fallback registered)", "/other", b.prefix)
+	}
+}
+
+func TestProxyHandlersAddDefaultsLocationToSlash(t *testing.T) {
+	ph := make(proxyHandlers)
+	ph.add(80, "", "10.0.0.1", "", http.NotFoundHandler())
+
+	got := ph.pick(80, "/anything", "", nil)
+	if got == nil || got.ipaddress != "10.0.0.1" {
+		t.Fatalf("pick with default location = %v, want handler for 10.0.0.1", got)
+	}
+}
+
+func TestProxyHandlersAddNormalizesTrailingSlash(t *testing.T) {
+	ph := make(proxyHandlers)
+	ph.add(80, "/api", "10.0.0.1", "", http.NotFoundHandler())
+
+	if got := ph.pick(80, "/apikey", "", nil); got != nil {
+		t.Fatalf("pick(%q) = %v, want nil: /apikey must not match location /api", "/apikey", got)
+	}
+	if got := ph.pick(80, "/api/v1", "", nil); got == nil {
+		t.Fatalf("pick(%q) = nil, want handler for 10.0.0.1", "/api/v1")
+	}
+}
@@ -0,0 +1,365 @@
+// Package proxy implements transport backends used to reach upstreams that
+// feature-proxy routes to, beyond the default HTTP RoundTripper.
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Transport is an http.RoundTripper that speaks the FastCGI protocol to a
+// single responder (e.g. php-fpm), letting a subdomain/port registered with
+// ReverseProxy front a FastCGI application instead of an HTTP upstream.
+type Transport struct {
+	// Network and Address select how to dial the responder, e.g.
+	// ("tcp", "127.0.0.1:9000") or ("unix", "/run/php-fpm.sock").
+	Network string
+	Address string
+
+	// Root is the document root used to build SCRIPT_FILENAME.
+	Root string
+	// SplitPath, when set, splits the incoming URL path into
+	// (SCRIPT_NAME, PATH_INFO) right after the first occurrence of this
+	// string (e.g. ".php"), as php-fpm expects. The match is not limited to
+	// the end of a path segment.
+	SplitPath string
+
+	// DialTimeout bounds connecting to the responder. Zero means no
+	// timeout.
+	DialTimeout time.Duration
+
+	reqID uint32 // atomic, incremented per request
+}
+
+// RoundTrip implements http.RoundTripper by translating req into a FastCGI
+// BEGIN_REQUEST/PARAMS/STDIN exchange and reassembling the responder's
+// STDOUT records into an *http.Response. STDERR records are read and
+// discarded; their content does not appear in the response.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	conn, err := t.dial()
+	if err != nil {
+		return nil, fmt.Errorf("fastcgi: dial %s %s: %w", t.Network, t.Address, err)
+	}
+
+	id := uint16(atomic.AddUint32(&t.reqID, 1))
+	c := &fcgiConn{conn: conn, reqID: id}
+
+	if err := c.writeBeginRequest(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	params := t.buildParams(req)
+	if err := c.writeParams(params); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := c.writeStdin(req.Body); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	resp, err := c.readResponse(req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	// The connection is closed once the body has been fully read.
+	resp.Body = &closeOnEOFReader{r: resp.Body, closer: conn}
+	return resp, nil
+}
+
+func (t *Transport) dial() (net.Conn, error) {
+	if t.DialTimeout > 0 {
+		return net.DialTimeout(t.Network, t.Address, t.DialTimeout)
+	}
+	return net.Dial(t.Network, t.Address)
+}
+
+// buildParams translates an incoming http.Request into the CGI environment
+// variables a FastCGI responder expects.
+func (t *Transport) buildParams(req *http.Request) map[string]string {
+	scriptName, pathInfo := t.splitScriptPath(req.URL.Path)
+
+	params := map[string]string{
+		"REQUEST_METHOD":    req.Method,
+		"SERVER_PROTOCOL":   req.Proto,
+		"QUERY_STRING":      req.URL.RawQuery,
+		"SCRIPT_FILENAME":   filepath.Join(t.Root, scriptName),
+		"SCRIPT_NAME":       scriptName,
+		"PATH_INFO":         pathInfo,
+		"REQUEST_URI":       req.URL.RequestURI(),
+		"DOCUMENT_ROOT":     t.Root,
+		"SERVER_SOFTWARE":   "feature-proxy",
+		"GATEWAY_INTERFACE": "CGI/1.1",
+		"REMOTE_ADDR":       remoteHost(req.RemoteAddr),
+		"CONTENT_TYPE":      req.Header.Get("Content-Type"),
+	}
+	if req.ContentLength >= 0 {
+		params["CONTENT_LENGTH"] = strconv.FormatInt(req.ContentLength, 10)
+	}
+	if host, port, err := net.SplitHostPort(req.Host); err == nil {
+		params["SERVER_NAME"] = host
+		params["SERVER_PORT"] = port
+	} else {
+		params["SERVER_NAME"] = req.Host
+	}
+
+	for name, values := range req.Header {
+		key := "HTTP_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+		params[key] = strings.Join(values, ", ")
+	}
+	return params
+}
+
+// splitScriptPath splits urlPath into (SCRIPT_NAME, PATH_INFO) at the first
+// occurrence of t.SplitPath, e.g. "/a.php/extra" with SplitPath ".php"
+// becomes ("/a.php", "/extra"). With no SplitPath configured, the whole
+// path is treated as SCRIPT_NAME.
+func (t *Transport) splitScriptPath(urlPath string) (scriptName, pathInfo string) {
+	if t.SplitPath == "" {
+		return urlPath, ""
+	}
+	if i := strings.Index(urlPath, t.SplitPath); i >= 0 {
+		cut := i + len(t.SplitPath)
+		return urlPath[:cut], urlPath[cut:]
+	}
+	return urlPath, ""
+}
+
+func remoteHost(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
+
+// closeOnEOFReader closes closer once r has been fully drained or an error
+// is seen, releasing the underlying FastCGI connection.
+type closeOnEOFReader struct {
+	r      io.Reader
+	closer io.Closer
+	closed bool
+}
+
+func (c *closeOnEOFReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if err != nil {
+		c.Close()
+	}
+	return n, err
+}
+
+func (c *closeOnEOFReader) Close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.closer.Close()
+}
+
+// --- FastCGI wire protocol ---
+
+const (
+	fcgiVersion1 = 1
+
+	fcgiBeginRequest = 1
+	fcgiParams       = 4
+	fcgiStdin        = 5
+	fcgiStdout       = 6
+	fcgiStderr       = 7
+	fcgiEndRequest   = 3
+
+	fcgiResponder = 1
+)
+
+type fcgiConn struct {
+	conn  net.Conn
+	reqID uint16
+}
+
+type fcgiHeader struct {
+	Version       uint8
+	Type          uint8
+	RequestID     uint16
+	ContentLength uint16
+	PaddingLength uint8
+	Reserved      uint8
+}
+
+func (c *fcgiConn) writeRecord(typ uint8, content []byte) error {
+	// content == nil means "write the empty record that terminates this
+	// stream" (PARAMS and STDIN are both required to end with one); run
+	// the loop body exactly once for that case regardless of typ.
+	for len(content) > 0 || content == nil {
+		chunk := content
+		if len(chunk) > 65535 {
+			chunk = chunk[:65535]
+		}
+		h := fcgiHeader{
+			Version:       fcgiVersion1,
+			Type:          typ,
+			RequestID:     c.reqID,
+			ContentLength: uint16(len(chunk)),
+		}
+		if err := writeHeader(c.conn, h); err != nil {
+			return err
+		}
+		if _, err := c.conn.Write(chunk); err != nil {
+			return err
+		}
+		content = content[len(chunk):]
+		if len(content) == 0 {
+			break
+		}
+	}
+	return nil
+}
+
+func writeHeader(w io.Writer, h fcgiHeader) error {
+	buf := []byte{h.Version, h.Type, byte(h.RequestID >> 8), byte(h.RequestID), byte(h.ContentLength >> 8), byte(h.ContentLength), h.PaddingLength, h.Reserved}
+	_, err := w.Write(buf)
+	return err
+}
+
+func (c *fcgiConn) writeBeginRequest() error {
+	body := []byte{0, fcgiResponder, 0 /* keep-conn off */, 0, 0, 0, 0, 0}
+	return c.writeRecord(fcgiBeginRequest, body)
+}
+
+func (c *fcgiConn) writeParams(params map[string]string) error {
+	var buf bytes.Buffer
+	for k, v := range params {
+		writeNameValue(&buf, k, v)
+	}
+	if buf.Len() > 0 {
+		if err := c.writeRecord(fcgiParams, buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	return c.writeRecord(fcgiParams, nil) // empty PARAMS record terminates the stream
+}
+
+func writeNameValue(buf *bytes.Buffer, name, value string) {
+	writeLength(buf, len(name))
+	writeLength(buf, len(value))
+	buf.WriteString(name)
+	buf.WriteString(value)
+}
+
+func writeLength(buf *bytes.Buffer, n int) {
+	if n <= 127 {
+		buf.WriteByte(byte(n))
+		return
+	}
+	buf.WriteByte(byte(n>>24)&0x7f | 0x80)
+	buf.WriteByte(byte(n >> 16))
+	buf.WriteByte(byte(n >> 8))
+	buf.WriteByte(byte(n))
+}
+
+func (c *fcgiConn) writeStdin(body io.Reader) error {
+	if body != nil {
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := body.Read(buf)
+			if n > 0 {
+				if werr := c.writeRecord(fcgiStdin, buf[:n]); werr != nil {
+					return werr
+				}
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return c.writeRecord(fcgiStdin, nil) // empty STDIN record terminates the stream
+}
+
+// readResponse reads STDOUT/STDERR records until END_REQUEST, parses the CGI
+// header block out of the STDOUT stream, and returns the remaining bytes as
+// an *http.Response whose Body streams from a pipe fed as records arrive.
+func (c *fcgiConn) readResponse(req *http.Request) (*http.Response, error) {
+	pr, pw := io.Pipe()
+	stdout := bufio.NewReader(pr)
+
+	go func() {
+		r := bufio.NewReader(c.conn)
+		for {
+			var h fcgiHeader
+			hdr := make([]byte, 8)
+			if _, err := io.ReadFull(r, hdr); err != nil {
+				pw.CloseWithError(fmt.Errorf("fastcgi: reading record header: %w", err))
+				return
+			}
+			h.Version = hdr[0]
+			h.Type = hdr[1]
+			h.RequestID = uint16(hdr[2])<<8 | uint16(hdr[3])
+			h.ContentLength = uint16(hdr[4])<<8 | uint16(hdr[5])
+			h.PaddingLength = hdr[6]
+
+			content := make([]byte, h.ContentLength)
+			if _, err := io.ReadFull(r, content); err != nil {
+				pw.CloseWithError(fmt.Errorf("fastcgi: reading record content: %w", err))
+				return
+			}
+			if h.PaddingLength > 0 {
+				if _, err := io.CopyN(io.Discard, r, int64(h.PaddingLength)); err != nil {
+					pw.CloseWithError(fmt.Errorf("fastcgi: reading record padding: %w", err))
+					return
+				}
+			}
+
+			switch h.Type {
+			case fcgiStdout:
+				if len(content) > 0 {
+					if _, err := pw.Write(content); err != nil {
+						return // reader side (http.Response.Body) already gave up
+					}
+				}
+			case fcgiStderr:
+				// STDERR content is discarded; it is not passed on to
+				// the caller in any form.
+			case fcgiEndRequest:
+				pw.Close() // clean EOF: the responder finished normally
+				return
+			}
+		}
+	}()
+
+	tp := textproto.NewReader(stdout)
+	mimeHeader, err := tp.ReadMIMEHeader()
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("fastcgi: parsing response headers: %w", err)
+	}
+
+	resp := &http.Response{
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Request:    req,
+		Header:     http.Header(mimeHeader),
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(stdout),
+	}
+	if status := resp.Header.Get("Status"); status != "" {
+		if code, statusErr := strconv.Atoi(strings.Fields(status)[0]); statusErr == nil {
+			resp.StatusCode = code
+		}
+		resp.Header.Del("Status")
+	}
+	return resp, nil
+}
@@ -0,0 +1,234 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fcgiRecord is a parsed record as read off the wire by the test responder.
+type fcgiRecord struct {
+	typ     uint8
+	content []byte
+}
+
+func readRecords(t *testing.T, r io.Reader) []fcgiRecord {
+	t.Helper()
+	var records []fcgiRecord
+	for {
+		hdr := make([]byte, 8)
+		if _, err := io.ReadFull(r, hdr); err != nil {
+			if err == io.EOF {
+				return records
+			}
+			t.Fatalf("reading record header: %s", err)
+		}
+		typ := hdr[1]
+		contentLength := int(hdr[4])<<8 | int(hdr[5])
+		padding := int(hdr[6])
+
+		content := make([]byte, contentLength)
+		if _, err := io.ReadFull(r, content); err != nil {
+			t.Fatalf("reading record content: %s", err)
+		}
+		if padding > 0 {
+			if _, err := io.CopyN(io.Discard, r, int64(padding)); err != nil {
+				t.Fatalf("reading record padding: %s", err)
+			}
+		}
+		records = append(records, fcgiRecord{typ: typ, content: content})
+
+		if typ == fcgiStdin && contentLength == 0 {
+			// STDIN's terminator is the last record the client sends
+			// before it's our turn to reply; stop so the test doesn't
+			// block waiting for more bytes that never come.
+			return records
+		}
+	}
+}
+
+// TestTransportSendsEmptyStdinTerminator is a regression test: writeRecord
+// used to only special-case an empty PARAMS record, so writeStdin's
+// terminating call (fcgiStdin, nil) was silently dropped and a real
+// responder would hang forever waiting for end-of-body on stdin.
+func TestTransportSendsEmptyStdinTerminator(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	defer ln.Close()
+
+	done := make(chan struct{})
+	var records []fcgiRecord
+	go func() {
+		defer close(done)
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		records = readRecords(t, conn)
+		writeMinimalFastCGIResponse(t, conn)
+	}()
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+	defer client.Close()
+
+	c := &fcgiConn{conn: client, reqID: 1}
+	if err := c.writeBeginRequest(); err != nil {
+		t.Fatalf("writeBeginRequest: %s", err)
+	}
+	if err := c.writeParams(map[string]string{"REQUEST_METHOD": "POST"}); err != nil {
+		t.Fatalf("writeParams: %s", err)
+	}
+	if err := c.writeStdin(strings.NewReader("hello")); err != nil {
+		t.Fatalf("writeStdin: %s", err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("responder never saw the STDIN terminator; writeStdin is hanging")
+	}
+
+	var sawStdinBody, sawStdinTerminator bool
+	for _, r := range records {
+		if r.typ == fcgiStdin {
+			if len(r.content) == 0 {
+				sawStdinTerminator = true
+			} else {
+				sawStdinBody = true
+			}
+		}
+	}
+	if !sawStdinBody {
+		t.Error("never saw a STDIN record carrying the request body")
+	}
+	if !sawStdinTerminator {
+		t.Error("never saw the empty STDIN record that terminates the stream")
+	}
+}
+
+// writeMinimalFastCGIResponse writes a tiny valid STDOUT + END_REQUEST
+// sequence so RoundTrip's reader can complete.
+func writeMinimalFastCGIResponse(t *testing.T, w io.Writer) {
+	t.Helper()
+	body := []byte("Status: 200 OK\r\nContent-Type: text/plain\r\n\r\nok")
+	if err := writeTestRecord(w, fcgiStdout, body); err != nil {
+		t.Fatalf("writing stdout record: %s", err)
+	}
+	if err := writeTestRecord(w, fcgiStdout, nil); err != nil {
+		t.Fatalf("writing stdout terminator: %s", err)
+	}
+	endRequest := []byte{0, 0, 0, 0, 0, 0, 0, 0}
+	if err := writeTestRecord(w, fcgiEndRequest, endRequest); err != nil {
+		t.Fatalf("writing end-request record: %s", err)
+	}
+}
+
+func writeTestRecord(w io.Writer, typ uint8, content []byte) error {
+	h := fcgiHeader{Version: fcgiVersion1, Type: typ, RequestID: 1, ContentLength: uint16(len(content))}
+	if err := writeHeader(w, h); err != nil {
+		return err
+	}
+	_, err := w.Write(content)
+	return err
+}
+
+func TestTransportRoundTrip(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		readRecords(t, conn)
+		writeMinimalFastCGIResponse(t, conn)
+	}()
+
+	tr := &Transport{Network: "tcp", Address: ln.Addr().String(), Root: "/var/www"}
+	req := httptest.NewRequest(http.MethodGet, "http://example.test/index.php", nil)
+
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("StatusCode = %d, want 200", resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading body: %s", err)
+	}
+	if string(body) != "ok" {
+		t.Errorf("body = %q, want %q", body, "ok")
+	}
+}
+
+// TestTransportRoundTripSurfacesTruncatedResponseError is a regression test:
+// readResponse's background reader used to close the pipe with plain
+// pw.Close() on every exit path, so a connection that died mid-response
+// (before END_REQUEST) looked like a clean io.EOF to the caller instead of
+// an error, silently truncating the body.
+func TestTransportRoundTripSurfacesTruncatedResponseError(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		readRecords(t, conn)
+		body := []byte("Status: 200 OK\r\nContent-Type: text/plain\r\n\r\nincomplete")
+		_ = writeTestRecord(conn, fcgiStdout, body)
+		// Close without ever sending END_REQUEST, simulating the
+		// responder dying mid-response.
+		conn.Close()
+	}()
+
+	tr := &Transport{Network: "tcp", Address: ln.Addr().String(), Root: "/var/www"}
+	req := httptest.NewRequest(http.MethodGet, "http://example.test/index.php", nil)
+
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if _, err := io.ReadAll(resp.Body); err == nil {
+		t.Fatal("reading body succeeded with no error, want the connection drop to surface as a read error")
+	}
+}
+
+func TestSplitScriptPath(t *testing.T) {
+	tr := &Transport{SplitPath: ".php"}
+	scriptName, pathInfo := tr.splitScriptPath("/a.php/extra")
+	if scriptName != "/a.php" || pathInfo != "/extra" {
+		t.Errorf("splitScriptPath = (%q, %q), want (%q, %q)", scriptName, pathInfo, "/a.php", "/extra")
+	}
+
+	tr = &Transport{}
+	scriptName, pathInfo = tr.splitScriptPath("/a.php/extra")
+	if scriptName != "/a.php/extra" || pathInfo != "" {
+		t.Errorf("splitScriptPath with no SplitPath = (%q, %q), want (%q, %q)", scriptName, pathInfo, "/a.php/extra", "")
+	}
+}
@@ -0,0 +1,405 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FastTransport is an http.RoundTripper for HTTP/1.1 upstreams that skips
+// net/http's client machinery: it keeps a pool of raw (optionally TLS) TCP
+// connections per upstream, writes the request line and headers directly to
+// a buffered writer, and parses the response with a plain bufio.Reader
+// instead of going through http.ReadResponse. Request header names are
+// written as given; response header names are still canonicalized, since
+// they are stored with http.Header.Add.
+// It exists purely as a lower-overhead alternative for the common
+// HTTP/1.1-upstream case; it is not a general-purpose client.
+type FastTransport struct {
+	// DialTimeout bounds establishing a new pooled connection.
+	DialTimeout time.Duration
+	// TLSConfig, when set, is used to dial upstreams reached over TLS
+	// (Addr must then be a host:port that speaks TLS).
+	TLSConfig *tls.Config
+	// MaxIdleConnsPerHost caps how many idle connections are kept per
+	// upstream address. Zero means a reasonable default.
+	MaxIdleConnsPerHost int
+
+	mu    sync.Mutex
+	pools map[string]*connPool
+}
+
+const defaultMaxIdleConnsPerHost = 8
+
+func (t *FastTransport) poolFor(addr string) *connPool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.pools == nil {
+		t.pools = make(map[string]*connPool)
+	}
+	p, ok := t.pools[addr]
+	if !ok {
+		max := t.MaxIdleConnsPerHost
+		if max <= 0 {
+			max = defaultMaxIdleConnsPerHost
+		}
+		p = &connPool{addr: addr, max: max, dial: t.dialer(addr)}
+		t.pools[addr] = p
+	}
+	return p
+}
+
+func (t *FastTransport) dialer(addr string) func() (net.Conn, error) {
+	return func() (net.Conn, error) {
+		dialer := &net.Dialer{Timeout: t.DialTimeout}
+		if t.TLSConfig != nil {
+			return tls.DialWithDialer(dialer, "tcp", addr, t.TLSConfig)
+		}
+		return dialer.Dial("tcp", addr)
+	}
+}
+
+// RoundTrip implements http.RoundTripper. req.URL.Host selects the upstream
+// connection pool. On a successful Upgrade/101 response the client
+// connection (from req's context via http.Hijacker, set up by the caller's
+// handler) is expected to be spliced to the pooled connection by the
+// caller; RoundTrip itself just returns the raw response and leaves the
+// connection unreturned to the pool so the caller can take over the byte
+// stream.
+func (t *FastTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	addr := req.URL.Host
+	pool := t.poolFor(addr)
+
+	conn, pooled, err := pool.get()
+	if err != nil {
+		return nil, fmt.Errorf("fastproxy: dial %s: %w", addr, err)
+	}
+
+	bw := bufio.NewWriter(conn)
+	if err := writeRequest(bw, req); err != nil {
+		conn.Close()
+		// A pooled connection may have been closed by the peer between
+		// being handed out and being written to; retry once on a fresh
+		// connection before giving up.
+		if pooled {
+			return t.retryFresh(pool, req)
+		}
+		return nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := readResponse(br, req)
+	if err != nil {
+		conn.Close()
+		if pooled {
+			return t.retryFresh(pool, req)
+		}
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		resp.Body = nil
+		resp.Header.Set("_fastproxy-hijack", "1")
+		return withHijackedConn(resp, conn, br), nil
+	}
+
+	resp.Body = &pooledBodyReader{r: br, conn: conn, pool: pool, contentLength: resp.ContentLength}
+	return resp, nil
+}
+
+func (t *FastTransport) retryFresh(pool *connPool, req *http.Request) (*http.Response, error) {
+	conn, err := pool.dial()
+	if err != nil {
+		return nil, fmt.Errorf("fastproxy: dial %s: %w", pool.addr, err)
+	}
+	bw := bufio.NewWriter(conn)
+	if err := writeRequest(bw, req); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := readResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body = &pooledBodyReader{r: br, conn: conn, pool: pool, contentLength: resp.ContentLength}
+	return resp, nil
+}
+
+// writeRequest writes the request line and headers directly to w, then
+// streams req.Body, avoiding net/http's Request.Write header
+// canonicalization and chunked-encoding bookkeeping for the common
+// Content-Length case.
+func writeRequest(w *bufio.Writer, req *http.Request) error {
+	uri := req.URL.RequestURI()
+	if _, err := fmt.Fprintf(w, "%s %s HTTP/1.1\r\n", req.Method, uri); err != nil {
+		return err
+	}
+	if req.Header.Get("Host") == "" {
+		if _, err := fmt.Fprintf(w, "Host: %s\r\n", req.Host); err != nil {
+			return err
+		}
+	}
+	// req.ContentLength == -1 is the normal case for a streamed/chunked
+	// upload (net/http sets it that way for an inbound request whose client
+	// sent Transfer-Encoding: chunked); without framing of our own, the
+	// upstream has no way to delimit the body on a keep-alive connection.
+	chunked := req.Body != nil && req.ContentLength < 0
+	if req.ContentLength > 0 && req.Header.Get("Content-Length") == "" {
+		if _, err := fmt.Fprintf(w, "Content-Length: %d\r\n", req.ContentLength); err != nil {
+			return err
+		}
+	} else if chunked && req.Header.Get("Transfer-Encoding") == "" {
+		if _, err := w.WriteString("Transfer-Encoding: chunked\r\n"); err != nil {
+			return err
+		}
+	}
+	for name, values := range req.Header {
+		for _, v := range values {
+			if _, err := fmt.Fprintf(w, "%s: %s\r\n", name, v); err != nil {
+				return err
+			}
+		}
+	}
+	if _, err := w.WriteString("\r\n"); err != nil {
+		return err
+	}
+	if req.Body != nil {
+		if chunked {
+			if err := writeChunkedBody(w, req.Body); err != nil {
+				return err
+			}
+		} else if _, err := io.Copy(w, req.Body); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// writeChunkedBody streams body onto w using HTTP/1.1 chunked
+// transfer-encoding framing (RFC 7230 4.1), for a request body whose length
+// isn't known up front.
+func writeChunkedBody(w *bufio.Writer, body io.Reader) error {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			if _, werr := fmt.Fprintf(w, "%x\r\n", n); werr != nil {
+				return werr
+			}
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return werr
+			}
+			if _, werr := w.WriteString("\r\n"); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err := w.WriteString("0\r\n\r\n")
+	return err
+}
+
+// readResponse parses a status line and headers directly off br without
+// going through http.ReadResponse, returning an *http.Response whose Body
+// is left nil for the caller to set.
+func readResponse(br *bufio.Reader, req *http.Request) (*http.Response, error) {
+	statusLine, err := br.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	statusLine = strings.TrimRight(statusLine, "\r\n")
+	parts := strings.SplitN(statusLine, " ", 3)
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("fastproxy: malformed status line %q", statusLine)
+	}
+	code, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("fastproxy: malformed status code %q", parts[1])
+	}
+
+	resp := &http.Response{
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Status:     strings.TrimPrefix(statusLine, parts[0]+" "),
+		StatusCode: code,
+		Header:     make(http.Header),
+		Request:    req,
+	}
+
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		i := strings.IndexByte(line, ':')
+		if i < 0 {
+			continue
+		}
+		name := strings.TrimSpace(line[:i])
+		value := strings.TrimSpace(line[i+1:])
+		resp.Header.Add(name, value)
+	}
+
+	if cl := resp.Header.Get("Content-Length"); cl != "" {
+		if n, err := strconv.ParseInt(cl, 10, 64); err == nil {
+			resp.ContentLength = n
+		}
+	} else {
+		resp.ContentLength = -1
+	}
+	return resp, nil
+}
+
+// pooledBodyReader reads a known-length (or connection-closed) response
+// body straight off the pooled connection's bufio.Reader, returning the
+// connection to its pool on Close (only once a known-length body has been
+// read in full) instead of letting net/http's Transport manage it.
+type pooledBodyReader struct {
+	r             *bufio.Reader
+	conn          net.Conn
+	pool          *connPool
+	contentLength int64
+	read          int64
+	closed        bool
+}
+
+func (b *pooledBodyReader) Read(p []byte) (int, error) {
+	if b.contentLength >= 0 {
+		remaining := b.contentLength - b.read
+		if remaining <= 0 {
+			return 0, io.EOF
+		}
+		if int64(len(p)) > remaining {
+			p = p[:remaining]
+		}
+	}
+	n, err := b.r.Read(p)
+	b.read += int64(n)
+	if err == nil && b.contentLength >= 0 && b.read >= b.contentLength {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (b *pooledBodyReader) Close() error {
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	if b.contentLength >= 0 && b.read >= b.contentLength {
+		b.pool.put(b.conn)
+		return nil
+	}
+	// Body wasn't fully drained (or length is unknown); the connection's
+	// state is unclear, so don't return it to the pool.
+	return b.conn.Close()
+}
+
+// withHijackedConn stashes the raw connection and its buffered reader on
+// the response via HijackedConn so the caller (the HTTP handler serving
+// the client) can splice the client connection to it for bidirectional
+// copying, keeping WebSockets working end to end.
+func withHijackedConn(resp *http.Response, conn net.Conn, br *bufio.Reader) *http.Response {
+	resp.Body = io.NopCloser(bytes.NewReader(nil))
+	resp.Close = true
+	resp.Header.Set("_fastproxy-hijack", "1")
+	hijacked.store(resp, &HijackedConn{Conn: conn, Reader: br})
+	return resp
+}
+
+// HijackedConn is the raw upgraded connection behind a 101 Switching
+// Protocols response, handed back to the caller so it can copy bytes
+// bidirectionally between it and the hijacked client connection.
+type HijackedConn struct {
+	Conn   net.Conn
+	Reader *bufio.Reader
+}
+
+// Hijacked returns the HijackedConn stashed for resp by a 101 response, if
+// any.
+func Hijacked(resp *http.Response) (*HijackedConn, bool) {
+	return hijacked.load(resp)
+}
+
+var hijacked = newHijackRegistry()
+
+// hijackRegistry maps *http.Response to its HijackedConn. http.Response has
+// no spare field for this, so a side table keyed by pointer is the least
+// invasive option.
+type hijackRegistry struct {
+	mu sync.Mutex
+	m  map[*http.Response]*HijackedConn
+}
+
+func newHijackRegistry() *hijackRegistry {
+	return &hijackRegistry{m: make(map[*http.Response]*HijackedConn)}
+}
+
+func (h *hijackRegistry) store(resp *http.Response, c *HijackedConn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.m[resp] = c
+}
+
+func (h *hijackRegistry) load(resp *http.Response) (*HijackedConn, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	c, ok := h.m[resp]
+	delete(h.m, resp)
+	return c, ok
+}
+
+// connPool is a small per-upstream pool of idle connections.
+type connPool struct {
+	mu   sync.Mutex
+	addr string
+	max  int
+	idle []net.Conn
+	dial func() (net.Conn, error)
+}
+
+// get returns an idle connection if one is available, or dials a new one.
+// The bool result reports whether the connection came from the pool (and
+// so may have gone stale since last use).
+func (p *connPool) get() (net.Conn, bool, error) {
+	p.mu.Lock()
+	if n := len(p.idle); n > 0 {
+		conn := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+		return conn, true, nil
+	}
+	p.mu.Unlock()
+	conn, err := p.dial()
+	return conn, false, err
+}
+
+func (p *connPool) put(conn net.Conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.idle) >= p.max {
+		conn.Close()
+		return
+	}
+	p.idle = append(p.idle, conn)
+}
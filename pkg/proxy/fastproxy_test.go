@@ -0,0 +1,240 @@
+package proxy
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFastTransportRoundTrip(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Test", "yes")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("hello from upstream"))
+	}))
+	defer ts.Close()
+
+	tr := &FastTransport{}
+	req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+	if err != nil {
+		t.Fatalf("NewRequest: %s", err)
+	}
+
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("StatusCode = %d, want 200", resp.StatusCode)
+	}
+	if got := resp.Header.Get("X-Test"); got != "yes" {
+		t.Errorf("header X-Test = %q, want %q", got, "yes")
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading body: %s", err)
+	}
+	if string(body) != "hello from upstream" {
+		t.Errorf("body = %q, want %q", body, "hello from upstream")
+	}
+}
+
+func TestFastTransportReusesPooledConnections(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	tr := &FastTransport{}
+	for i := 0; i < 3; i++ {
+		req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+		if err != nil {
+			t.Fatalf("NewRequest: %s", err)
+		}
+		resp, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatalf("RoundTrip #%d: %s", i, err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	pool := tr.poolFor(ts.Listener.Addr().String())
+	pool.mu.Lock()
+	idle := len(pool.idle)
+	pool.mu.Unlock()
+	if idle == 0 {
+		t.Error("expected at least one connection returned to the idle pool after fully-drained responses")
+	}
+}
+
+// TestFastTransportWritesChunkedBodyForUnknownLength is a regression test:
+// writeRequest used to only emit Content-Length for req.ContentLength > 0,
+// leaving a request body of unknown length (ContentLength == -1, the normal
+// case for an inbound chunked upload) completely unframed. A real net/http
+// server backing the upstream (as used here) only decodes the body
+// correctly if it actually sees Transfer-Encoding: chunked framing.
+func TestFastTransportWritesChunkedBodyForUnknownLength(t *testing.T) {
+	const want = "streamed request body of unknown length"
+	var gotBody string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("server reading body: %s", err)
+			return
+		}
+		gotBody = string(body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	req, err := http.NewRequest(http.MethodPost, ts.URL, io.NopCloser(strings.NewReader(want)))
+	if err != nil {
+		t.Fatalf("NewRequest: %s", err)
+	}
+	// Simulate what net/http sets on an inbound request whose client sent
+	// Transfer-Encoding: chunked, rather than relying on NewRequest's
+	// body-type sniffing (which only recognizes a handful of concrete types).
+	req.ContentLength = -1
+
+	tr := &FastTransport{}
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip: %s", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("StatusCode = %d, want 200", resp.StatusCode)
+	}
+	if gotBody != want {
+		t.Errorf("server saw body = %q, want %q", gotBody, want)
+	}
+}
+
+// TestFastTransportHijacksOnSwitchingProtocols exercises the Upgrade/101
+// path the request explicitly calls out for keeping WebSockets working:
+// RoundTrip must stash the raw connection via HijackedConn instead of
+// returning it to the pool, and bytes must flow both ways after the upgrade.
+func TestFastTransportHijacksOnSwitchingProtocols(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	defer ln.Close()
+
+	serverDone := make(chan struct{})
+	go func() {
+		defer close(serverDone)
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		br := bufio.NewReader(conn)
+		for {
+			line, err := br.ReadString('\n')
+			if err != nil || line == "\r\n" {
+				break
+			}
+		}
+		io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		io.WriteString(conn, "post-upgrade-from-upstream")
+
+		buf := make([]byte, len("post-upgrade-from-client"))
+		if _, err := io.ReadFull(br, buf); err != nil {
+			t.Errorf("upstream reading post-upgrade bytes: %s", err)
+			return
+		}
+		if string(buf) != "post-upgrade-from-client" {
+			t.Errorf("upstream saw %q, want %q", buf, "post-upgrade-from-client")
+		}
+	}()
+
+	tr := &FastTransport{}
+	req, err := http.NewRequest(http.MethodGet, "http://"+ln.Addr().String()+"/ws", nil)
+	if err != nil {
+		t.Fatalf("NewRequest: %s", err)
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip: %s", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("StatusCode = %d, want 101", resp.StatusCode)
+	}
+
+	hj, ok := Hijacked(resp)
+	if !ok {
+		t.Fatal("Hijacked(resp) = false, want a stashed HijackedConn for a 101 response")
+	}
+	defer hj.Conn.Close()
+
+	buf := make([]byte, len("post-upgrade-from-upstream"))
+	if _, err := io.ReadFull(hj.Reader, buf); err != nil {
+		t.Fatalf("reading post-upgrade bytes: %s", err)
+	}
+	if string(buf) != "post-upgrade-from-upstream" {
+		t.Errorf("post-upgrade bytes = %q, want %q", buf, "post-upgrade-from-upstream")
+	}
+	if _, err := hj.Conn.Write([]byte("post-upgrade-from-client")); err != nil {
+		t.Fatalf("writing post-upgrade bytes: %s", err)
+	}
+
+	<-serverDone
+}
+
+func benchmarkRoundTripper(b *testing.B, rt http.RoundTripper, makeReq func() *http.Request) {
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		resp, err := rt.RoundTrip(makeReq())
+		if err != nil {
+			b.Fatalf("RoundTrip: %s", err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+}
+
+// BenchmarkFastTransport and BenchmarkHTTPTransport measure allocs/req for
+// small responses, the case FastTransport is meant to help: a pooled raw
+// connection and a hand-rolled parser versus net/http's general-purpose
+// client.
+func BenchmarkFastTransport(b *testing.B) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	tr := &FastTransport{}
+	benchmarkRoundTripper(b, tr, func() *http.Request {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+		return req
+	})
+}
+
+func BenchmarkHTTPTransport(b *testing.B) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	tr := &http.Transport{}
+	defer tr.CloseIdleConnections()
+	benchmarkRoundTripper(b, tr, func() *http.Request {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+		return req
+	})
+}
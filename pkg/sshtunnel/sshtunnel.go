@@ -0,0 +1,409 @@
+// Package sshtunnel lets a developer expose a local service as a
+// feature-proxy subdomain over a plain "ssh -R" tunnel, without deploying a
+// separate registration agent.
+package sshtunnel
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+
+	feature "github.com/miseyu/feature-proxy"
+)
+
+// SubdomainPolicy derives the subdomain a session is allowed to register
+// for, given the authenticated SSH user and the subdomain requested via
+// "-R subdomain:0:localhost:PORT" (empty if the client didn't request one).
+// It returns ok=false to refuse the registration.
+type SubdomainPolicy func(sshUser string, requested string) (subdomain string, ok bool)
+
+// DefaultSubdomainPolicy allows a session to register only the subdomain
+// matching its SSH username, or any subdomain prefixed with
+// "<user>-" (e.g. user "alice" may register "alice" or "alice-preview").
+func DefaultSubdomainPolicy(sshUser string, requested string) (string, bool) {
+	if requested == "" || requested == sshUser || strings.HasPrefix(requested, sshUser+"-") {
+		if requested == "" {
+			return sshUser, true
+		}
+		return requested, true
+	}
+	return "", false
+}
+
+// Config configures a Server.
+type Config struct {
+	// ListenAddr is the address the SSH server listens on, e.g. ":2222".
+	ListenAddr string
+	// HostKeyPath is the path to the server's PEM-encoded SSH host key.
+	HostKeyPath string
+	// AuthorizedKeysPath is a path in authorized_keys format; a client must
+	// present a key listed there. Required: with no authorized-keys list
+	// configured, the server has no way to authenticate a client, so it
+	// registers no auth method at all and every handshake fails closed.
+	AuthorizedKeysPath string
+	// AllowedUsers, if non-empty, restricts which SSH usernames may
+	// connect at all, independent of SubdomainPolicy.
+	AllowedUsers []string
+	// SubdomainPolicy decides which subdomain a session may register.
+	// Defaults to DefaultSubdomainPolicy.
+	SubdomainPolicy SubdomainPolicy
+	// Port is the (subdomain, port) a registered upstream is reachable on
+	// through ReverseProxy, e.g. 80.
+	Port int
+}
+
+// Server is a minimal SSH server that only understands "-R" remote
+// port-forward requests and uses them to register/deregister upstreams with
+// a feature.ReverseProxy.
+type Server struct {
+	cfg       Config
+	sshConfig *ssh.ServerConfig
+	proxy     *feature.ReverseProxy
+
+	mu       sync.Mutex
+	sessions map[*ssh.ServerConn]*session
+}
+
+// session tracks the registrations owned by one SSH connection so they can
+// be torn down when it disconnects.
+type session struct {
+	conn          *ssh.ServerConn
+	subdomain     string
+	virtualIP     string
+	registrations []feature.ProxyControl
+}
+
+// NewServer builds a Server backed by proxy. Call ListenAndServe to start
+// accepting connections.
+func NewServer(cfg Config, proxy *feature.ReverseProxy) (*Server, error) {
+	if cfg.SubdomainPolicy == nil {
+		cfg.SubdomainPolicy = DefaultSubdomainPolicy
+	}
+
+	hostKeyBytes, err := os.ReadFile(cfg.HostKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("sshtunnel: reading host key: %w", err)
+	}
+	hostKey, err := ssh.ParsePrivateKey(hostKeyBytes)
+	if err != nil {
+		return nil, fmt.Errorf("sshtunnel: parsing host key: %w", err)
+	}
+
+	var authorizedKeys map[string]bool
+	if cfg.AuthorizedKeysPath != "" {
+		authorizedKeys, err = loadAuthorizedKeys(cfg.AuthorizedKeysPath)
+		if err != nil {
+			return nil, fmt.Errorf("sshtunnel: loading authorized_keys: %w", err)
+		}
+	}
+
+	s := &Server{
+		cfg:      cfg,
+		proxy:    proxy,
+		sessions: make(map[*ssh.ServerConn]*session),
+	}
+
+	sshConfig := &ssh.ServerConfig{}
+	// With no authorized-keys list there is nothing to check a presented key
+	// against, so leave PublicKeyCallback unset entirely: an *ssh.ServerConfig
+	// with no auth methods configured rejects every handshake, rather than
+	// accepting (and trusting) any key a client happens to offer.
+	if authorizedKeys != nil {
+		sshConfig.PublicKeyCallback = func(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if !s.userAllowed(meta.User()) {
+				return nil, fmt.Errorf("user %q not allowed", meta.User())
+			}
+			if !authorizedKeys[string(key.Marshal())] {
+				return nil, fmt.Errorf("unknown public key for user %q", meta.User())
+			}
+			return nil, nil
+		}
+	}
+	sshConfig.AddHostKey(hostKey)
+	s.sshConfig = sshConfig
+
+	return s, nil
+}
+
+func (s *Server) userAllowed(user string) bool {
+	if len(s.cfg.AllowedUsers) == 0 {
+		return true
+	}
+	for _, u := range s.cfg.AllowedUsers {
+		if u == user {
+			return true
+		}
+	}
+	return false
+}
+
+func loadAuthorizedKeys(path string) (map[string]bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]bool)
+	for len(data) > 0 {
+		key, _, _, rest, err := ssh.ParseAuthorizedKey(data)
+		if err != nil {
+			break
+		}
+		out[string(key.Marshal())] = true
+		data = rest
+	}
+	return out, nil
+}
+
+// ListenAndServe accepts connections on cfg.ListenAddr until the listener is
+// closed or an unrecoverable error occurs.
+func (s *Server) ListenAndServe() error {
+	ln, err := net.Listen("tcp", s.cfg.ListenAddr)
+	if err != nil {
+		return fmt.Errorf("sshtunnel: listen %s: %w", s.cfg.ListenAddr, err)
+	}
+	defer ln.Close()
+	return s.Serve(ln)
+}
+
+// Serve accepts connections on ln until it is closed or an unrecoverable
+// error occurs. It is split out from ListenAndServe so callers (tests, or a
+// host process that wants to pick its own listener, e.g. for an ephemeral
+// port) can supply their own net.Listener.
+func (s *Server) Serve(ln net.Listener) error {
+	slog.Info(fmt.Sprintf("sshtunnel: listening on %s", ln.Addr()))
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go s.handleConn(conn)
+	}
+}
+
+func (s *Server) handleConn(netConn net.Conn) {
+	sshConn, chans, reqs, err := ssh.NewServerConn(netConn, s.sshConfig)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("sshtunnel: handshake failed: %s", err))
+		netConn.Close()
+		return
+	}
+	defer s.teardown(sshConn)
+
+	go s.handleChannels(chans)
+	go func() {
+		for req := range reqs {
+			s.HandleGlobalRequest(sshConn, req)
+		}
+	}()
+
+	_ = sshConn.Wait()
+}
+
+// handleChannels rejects inbound channel-open requests: this server only
+// originates "forwarded-tcpip" channels back to the client, it never
+// accepts session/direct channels from it.
+func (s *Server) handleChannels(chans <-chan ssh.NewChannel) {
+	for ch := range chans {
+		_ = ch.Reject(ssh.UnknownChannelType, "sshtunnel: only tcpip-forward is supported")
+	}
+}
+
+// tcpipForwardPayload mirrors RFC 4254 7.1's "tcpip-forward" request body.
+type tcpipForwardPayload struct {
+	BindAddr string
+	BindPort uint32
+}
+
+// HandleGlobalRequest processes one out-of-band global request from conn
+// (the caller is expected to range over conn's requests channel and call
+// this per request, see handleConn's internal loop). It is exported so
+// a caller embedding Server in a larger SSH listener can reuse the
+// tcpip-forward handling logic.
+func (s *Server) HandleGlobalRequest(conn *ssh.ServerConn, req *ssh.Request) {
+	switch req.Type {
+	case "tcpip-forward":
+		s.handleForward(conn, req)
+	case "cancel-tcpip-forward":
+		s.handleCancelForward(conn, req)
+	default:
+		if req.WantReply {
+			_ = req.Reply(false, nil)
+		}
+	}
+}
+
+func (s *Server) handleForward(conn *ssh.ServerConn, req *ssh.Request) {
+	var payload tcpipForwardPayload
+	if err := unmarshalForward(req.Payload, &payload); err != nil {
+		slog.Warn(fmt.Sprintf("sshtunnel: malformed tcpip-forward from %s: %s", conn.User(), err))
+		if req.WantReply {
+			_ = req.Reply(false, nil)
+		}
+		return
+	}
+
+	subdomain, ok := s.cfg.SubdomainPolicy(conn.User(), payload.BindAddr)
+	if !ok {
+		slog.Warn(fmt.Sprintf("sshtunnel: user %s not allowed to register subdomain %q", conn.User(), payload.BindAddr))
+		if req.WantReply {
+			_ = req.Reply(false, nil)
+		}
+		return
+	}
+
+	// The "IP address" stored in ReverseProxy is a virtual identifier: it
+	// doesn't name a routable host, it tags the per-session transport that
+	// dials back through this SSH connection's channel.
+	virtualIP := fmt.Sprintf("ssh-tunnel:%s:%d", conn.RemoteAddr(), payload.BindPort)
+	transport := &channelDialer{conn: conn, remotePort: payload.BindPort}
+	handler := &reverseProxyHandler{transport: transport}
+
+	ctrl := feature.ProxyControl{
+		Action:    feature.ProxyAdd,
+		Subdomain: subdomain,
+		IPAddress: virtualIP,
+		Port:      s.cfg.Port,
+		Kind:      feature.UpstreamKindSSHTunnel,
+	}
+	s.proxy.Register(ctrl, handler)
+	slog.Info(fmt.Sprintf("sshtunnel: registered %s -> %s (user %s)", subdomain, virtualIP, conn.User()))
+
+	s.mu.Lock()
+	sess, ok := s.sessions[conn]
+	if !ok {
+		sess = &session{conn: conn, subdomain: subdomain, virtualIP: virtualIP}
+		s.sessions[conn] = sess
+	}
+	sess.registrations = append(sess.registrations, ctrl)
+	s.mu.Unlock()
+
+	if req.WantReply {
+		reply := make([]byte, 4)
+		binary.BigEndian.PutUint32(reply, payload.BindPort)
+		_ = req.Reply(true, reply)
+	}
+}
+
+func (s *Server) handleCancelForward(conn *ssh.ServerConn, req *ssh.Request) {
+	var payload tcpipForwardPayload
+	_ = unmarshalForward(req.Payload, &payload)
+
+	s.mu.Lock()
+	if sess, ok := s.sessions[conn]; ok {
+		for _, ctrl := range sess.registrations {
+			s.proxy.Deregister(ctrl)
+		}
+		delete(s.sessions, conn)
+	}
+	s.mu.Unlock()
+
+	if req.WantReply {
+		_ = req.Reply(true, nil)
+	}
+}
+
+// teardown removes every registration owned by conn when its SSH session
+// ends, however it ended.
+func (s *Server) teardown(conn *ssh.ServerConn) {
+	s.mu.Lock()
+	sess, ok := s.sessions[conn]
+	delete(s.sessions, conn)
+	s.mu.Unlock()
+	if !ok {
+		return
+	}
+	for _, ctrl := range sess.registrations {
+		s.proxy.Deregister(ctrl)
+	}
+	slog.Info(fmt.Sprintf("sshtunnel: session for %s torn down, %d registration(s) removed", sess.subdomain, len(sess.registrations)))
+}
+
+func unmarshalForward(payload []byte, out *tcpipForwardPayload) error {
+	return ssh.Unmarshal(payload, out)
+}
+
+// channelDialer opens a new "forwarded-tcpip" channel per request, which is
+// how the virtual upstream is actually reached: there is no routable IP,
+// only a live SSH connection whose client is listening on remotePort.
+type channelDialer struct {
+	conn       *ssh.ServerConn
+	remotePort uint32
+}
+
+// forwardedTCPIPPayload mirrors RFC 4254 7.2's "forwarded-tcpip" channel
+// open payload.
+type forwardedTCPIPPayload struct {
+	ConnectedAddr  string
+	ConnectedPort  uint32
+	OriginatorAddr string
+	OriginatorPort uint32
+}
+
+func (d *channelDialer) dial() (ssh.Channel, error) {
+	payload := ssh.Marshal(&forwardedTCPIPPayload{
+		ConnectedAddr:  "localhost",
+		ConnectedPort:  d.remotePort,
+		OriginatorAddr: "localhost",
+		OriginatorPort: 0,
+	})
+	ch, reqs, err := d.conn.OpenChannel("forwarded-tcpip", payload)
+	if err != nil {
+		return nil, err
+	}
+	go ssh.DiscardRequests(reqs)
+	return ch, nil
+}
+
+// reverseProxyHandler proxies an incoming HTTP request over a fresh
+// forwarded-tcpip SSH channel to the tunnel's client.
+type reverseProxyHandler struct {
+	transport *channelDialer
+}
+
+func (h *reverseProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ch, err := h.transport.dial()
+	if err != nil {
+		http.Error(w, "upstream tunnel unavailable", http.StatusBadGateway)
+		return
+	}
+	defer ch.Close()
+
+	if err := r.Write(ch); err != nil {
+		http.Error(w, "failed writing to tunnel", http.StatusBadGateway)
+		return
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(ch), r)
+	if err != nil {
+		http.Error(w, "failed reading from tunnel", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	for k, vs := range resp.Header {
+		for _, v := range vs {
+			w.Header().Add(k, v)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	buf := make([]byte, 32*1024)
+	for {
+		n, rerr := resp.Body.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return
+			}
+		}
+		if rerr != nil {
+			return
+		}
+	}
+}
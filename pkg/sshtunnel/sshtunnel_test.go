@@ -0,0 +1,249 @@
+package sshtunnel
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	feature "github.com/miseyu/feature-proxy"
+)
+
+// writeHostKey generates an RSA host key and writes it PEM-encoded to a file
+// under t.TempDir, returning its path (NewServer only accepts a path, not an
+// in-memory key).
+func writeHostKey(t *testing.T) string {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating host key: %s", err)
+	}
+	block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	path := filepath.Join(t.TempDir(), "host_key")
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatalf("writing host key: %s", err)
+	}
+	return path
+}
+
+// newClientSigner generates a fresh RSA key pair and returns both an
+// ssh.Signer for the client side and the authorized_keys-format line for the
+// server side.
+func newClientSigner(t *testing.T) (ssh.Signer, string) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating client key: %s", err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatalf("NewSignerFromKey: %s", err)
+	}
+	return signer, string(ssh.MarshalAuthorizedKey(signer.PublicKey()))
+}
+
+// writeAuthorizedKeys writes lines (each already in authorized_keys format,
+// e.g. from newClientSigner) to a file under t.TempDir and returns its path.
+func writeAuthorizedKeys(t *testing.T, lines ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "authorized_keys")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "")), 0o600); err != nil {
+		t.Fatalf("writing authorized_keys: %s", err)
+	}
+	return path
+}
+
+// TestServerForwardsHTTPThroughTunnel spins up a Server and an in-process SSH
+// client that registers a remote forward for subdomain "alice", relays the
+// forwarded-tcpip channels it receives to a local httptest backend (standing
+// in for the real "ssh -R" client forwarding to a local service), then drives
+// an HTTP request through the registered ReverseProxy and checks it reaches
+// the backend.
+func TestServerForwardsHTTPThroughTunnel(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("hello through the tunnel"))
+	}))
+	defer backend.Close()
+
+	proxy := feature.NewReverseProxy(&feature.Config{})
+
+	signer, authorizedKeyLine := newClientSigner(t)
+	server, err := NewServer(Config{
+		HostKeyPath:        writeHostKey(t),
+		AuthorizedKeysPath: writeAuthorizedKeys(t, authorizedKeyLine),
+		Port:               80,
+	}, proxy)
+	if err != nil {
+		t.Fatalf("NewServer: %s", err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	defer ln.Close()
+	go server.Serve(ln)
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+	clientConn, chans, reqs, err := ssh.NewClientConn(conn, ln.Addr().String(), &ssh.ClientConfig{
+		User:            "alice",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatalf("ssh handshake: %s", err)
+	}
+	client := ssh.NewClient(clientConn, chans, reqs)
+	defer client.Close()
+
+	remoteLn, err := client.Listen("tcp", "alice:0")
+	if err != nil {
+		t.Fatalf("client.Listen (tcpip-forward): %s", err)
+	}
+	defer remoteLn.Close()
+
+	// Stand in for the real "ssh -R" client: relay each forwarded-tcpip
+	// channel to the local backend, exactly as an ssh binary would do
+	// against "localhost:PORT".
+	go func() {
+		for {
+			fwd, err := remoteLn.Accept()
+			if err != nil {
+				return
+			}
+			go relay(fwd, backend.Listener.Addr().String())
+		}
+	}()
+
+	handler := proxy.FindHandler("alice", 80)
+	if handler == nil {
+		t.Fatal("FindHandler(\"alice\", 80) = nil; tcpip-forward registration didn't reach the proxy")
+	}
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://alice.example.test/", nil)
+	done := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(rec, req)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("request through the tunnel never completed")
+	}
+
+	if rec.Body.String() != "hello through the tunnel" {
+		t.Errorf("body = %q, want %q", rec.Body.String(), "hello through the tunnel")
+	}
+}
+
+func relay(conn net.Conn, backendAddr string) {
+	defer conn.Close()
+	backendConn, err := net.Dial("tcp", backendAddr)
+	if err != nil {
+		return
+	}
+	defer backendConn.Close()
+
+	done := make(chan struct{}, 2)
+	go func() { io.Copy(backendConn, conn); done <- struct{}{} }()
+	go func() { io.Copy(conn, backendConn); done <- struct{}{} }()
+	<-done
+}
+
+// dialSSH attempts a handshake against server using signer, returning the
+// error (nil on success). The caller is responsible for closing the server.
+func dialSSH(t *testing.T, server *Server, signer ssh.Signer) error {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	defer ln.Close()
+	go server.Serve(ln)
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+	_, _, _, err = ssh.NewClientConn(conn, ln.Addr().String(), &ssh.ClientConfig{
+		User:            "alice",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	return err
+}
+
+// TestServerRejectsUnlistedKey is a regression test: AuthorizedKeysPath must
+// actually be enforced, not just consulted when convenient.
+func TestServerRejectsUnlistedKey(t *testing.T) {
+	proxy := feature.NewReverseProxy(&feature.Config{})
+	_, allowedKeyLine := newClientSigner(t)
+	strangerSigner, _ := newClientSigner(t)
+
+	server, err := NewServer(Config{
+		HostKeyPath:        writeHostKey(t),
+		AuthorizedKeysPath: writeAuthorizedKeys(t, allowedKeyLine),
+		Port:               80,
+	}, proxy)
+	if err != nil {
+		t.Fatalf("NewServer: %s", err)
+	}
+
+	if err := dialSSH(t, server, strangerSigner); err == nil {
+		t.Fatal("handshake succeeded with a key absent from authorized_keys, want rejection")
+	}
+}
+
+// TestServerRejectsEveryKeyWithoutAuthorizedKeysPath is a regression test: an
+// empty AuthorizedKeysPath used to leave PublicKeyCallback unconditionally
+// accepting any key, letting an unauthenticated client register arbitrary
+// subdomains. It must now fail closed instead.
+func TestServerRejectsEveryKeyWithoutAuthorizedKeysPath(t *testing.T) {
+	proxy := feature.NewReverseProxy(&feature.Config{})
+	signer, _ := newClientSigner(t)
+
+	server, err := NewServer(Config{HostKeyPath: writeHostKey(t), Port: 80}, proxy)
+	if err != nil {
+		t.Fatalf("NewServer: %s", err)
+	}
+
+	if err := dialSSH(t, server, signer); err == nil {
+		t.Fatal("handshake succeeded with no AuthorizedKeysPath configured, want rejection")
+	}
+}
+
+func TestDefaultSubdomainPolicy(t *testing.T) {
+	cases := []struct {
+		user, requested, wantSubdomain string
+		wantOK                         bool
+	}{
+		{"alice", "", "alice", true},
+		{"alice", "alice", "alice", true},
+		{"alice", "alice-preview", "alice-preview", true},
+		{"alice", "bob", "", false},
+	}
+	for _, c := range cases {
+		got, ok := DefaultSubdomainPolicy(c.user, c.requested)
+		if got != c.wantSubdomain || ok != c.wantOK {
+			t.Errorf("DefaultSubdomainPolicy(%q, %q) = (%q, %v), want (%q, %v)",
+				c.user, c.requested, got, ok, c.wantSubdomain, c.wantOK)
+		}
+	}
+}
@@ -5,56 +5,92 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"net"
 	"net/http"
 	"path"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-type proxyAction string
+type ProxyAction string
 
 const (
-	proxyAdd    = proxyAction("Add")
-	proxyRemove = proxyAction("Remove")
+	ProxyAdd    = ProxyAction("Add")
+	ProxyRemove = ProxyAction("Remove")
 )
 
 var proxyHandlerLifetime = 30 * time.Second
 
-type proxyControl struct {
-	Action    proxyAction
+type ProxyControl struct {
+	Action    ProxyAction
 	Subdomain string
 	IPAddress string
 	Port      int
+	// Location is the URL path prefix this upstream owns, e.g. "/api/".
+	// Empty is treated as the default "/" fallback.
+	Location string
+	// Kind selects the upstream transport: "" or "http" for a plain HTTP
+	// upstream, "fastcgi" to speak FastCGI (see pkg/proxy.Transport) to
+	// e.g. php-fpm instead.
+	Kind string
 }
 
+const upstreamKindFastCGI = "fastcgi"
+
+// UpstreamKindSSHTunnel marks an upstream whose "IP address" is actually a
+// virtual identifier dialed through a live SSH channel (see pkg/sshtunnel),
+// not something reachable by a plain HTTP GET.
+const UpstreamKindSSHTunnel = "ssh-tunnel"
+
 type ReverseProxy struct {
 	mu                sync.RWMutex
 	cfg               *Config
 	domains           []string
 	domainMap         map[string]proxyHandlers
 	accessCounterUnit time.Duration
+	lb                LoadBalancer
+	healthChecker     *healthChecker
 }
 
 func NewReverseProxy(cfg *Config) *ReverseProxy {
-	return &ReverseProxy{
+	r := &ReverseProxy{
 		cfg:       cfg,
 		domainMap: make(map[string]proxyHandlers),
+		lb:        NewLoadBalancer(cfg.lbPolicy()),
 	}
+	r.healthChecker = newHealthChecker(r, cfg.healthCheckInterval(), cfg.HealthCheckPath)
+	if cfg.HealthCheckPath != "" {
+		r.healthChecker.Start()
+	}
+	return r
 }
 
 func (r *ReverseProxy) ServeHTTPWithPort(w http.ResponseWriter, req *http.Request, port int) {
 	subdomain := strings.ToLower(strings.Split(req.Host, ".")[0])
+	clientIP := clientIPFromRequest(req)
 
-	if handler := r.FindHandler(subdomain, port); handler != nil {
+	if handler := r.findProxyHandler(subdomain, port, req.URL.Path, clientIP); handler != nil {
 		slog.Debug(f("proxy handler found for subdomain %s", subdomain))
-		handler.ServeHTTP(w, req)
+		atomic.AddInt64(&handler.inFlight, 1)
+		defer atomic.AddInt64(&handler.inFlight, -1)
+		handler.handler.ServeHTTP(w, req)
 	} else {
 		slog.Debug(f("proxy handler not found for subdomain %s", subdomain))
 		http.NotFound(w, req)
 	}
 }
 
+func clientIPFromRequest(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
 func (r *ReverseProxy) Exists(subdomain string) bool {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
@@ -70,6 +106,32 @@ func (r *ReverseProxy) Exists(subdomain string) bool {
 	return false
 }
 
+// Register adds subdomain as a known domain if needed and registers h as an
+// upstream for ctrl.Port under ctrl.Location (defaulting to "/").
+func (r *ReverseProxy) Register(ctrl ProxyControl, h http.Handler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.domainMap[ctrl.Subdomain]; !ok {
+		r.domainMap[ctrl.Subdomain] = make(proxyHandlers)
+		r.domains = append(r.domains, ctrl.Subdomain)
+	}
+	r.domainMap[ctrl.Subdomain].add(ctrl.Port, ctrl.Location, ctrl.IPAddress, ctrl.Kind, h)
+}
+
+// Deregister removes the upstream registered for
+// (ctrl.Subdomain, ctrl.Port, ctrl.Location, ctrl.IPAddress), if any.
+func (r *ReverseProxy) Deregister(ctrl ProxyControl) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ph, ok := r.domainMap[ctrl.Subdomain]
+	if !ok {
+		return
+	}
+	ph.remove(ctrl.Port, ctrl.Location, ctrl.IPAddress)
+}
+
 func (r *ReverseProxy) Subdomains() []string {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
@@ -79,9 +141,22 @@ func (r *ReverseProxy) Subdomains() []string {
 }
 
 func (r *ReverseProxy) FindHandler(subdomain string, port int) http.Handler {
+	h := r.findProxyHandler(subdomain, port, defaultLocation, "")
+	if h == nil {
+		return nil
+	}
+	return h.handler
+}
+
+// findProxyHandler resolves the proxyHandler that should serve a request for
+// (subdomain, port, urlPath), selecting the longest matching registered
+// location and, among its alive, healthy upstreams, the one chosen by the
+// configured LoadBalancer. clientIP is only consulted by policies that need
+// request affinity (e.g. ip-hash).
+func (r *ReverseProxy) findProxyHandler(subdomain string, port int, urlPath string, clientIP string) *proxyHandler {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
-	slog.Debug(f("FindHandler for %s:%d", subdomain, port))
+	slog.Debug(f("FindHandler for %s:%d%s", subdomain, port, urlPath))
 
 	proxyHandlers, ok := r.domainMap[subdomain]
 	if !ok {
@@ -96,22 +171,40 @@ func (r *ReverseProxy) FindHandler(subdomain string, port int) http.Handler {
 		}
 	}
 
-	handler, ok := proxyHandlers.Handler(port)
-	if !ok {
-		return nil
-	}
-	return handler
+	return proxyHandlers.pick(port, urlPath, clientIP, r.lb)
 }
 
+// passiveBackoffBase is the initial backoff applied to an upstream after a
+// RoundTrip failure, doubling on each consecutive failure up to 64x the base
+// (after seven failures) before the upstream is re-admitted to the pool.
+const passiveBackoffBase = 1 * time.Second
+
 type proxyHandler struct {
-	handler http.Handler
-	timer   *time.Timer
+	handler   http.Handler
+	timer     *time.Timer
+	ipaddress string
+	port      int
+	// kind is the upstream transport (see ProxyControl.Kind), used by the
+	// health checker to decide whether ipaddress is even reachable over
+	// plain HTTP.
+	kind string
+
+	inFlight int64 // atomic: number of in-flight requests, used by least-conn
+
+	mu           sync.Mutex
+	healthy      bool      // active health-check verdict; true until a check fails
+	failCount    int       // consecutive passive (RoundTrip) failures
+	backoffUntil time.Time // set on passive failure, cleared on success
 }
 
-func newProxyHandler(h http.Handler) *proxyHandler {
+func newProxyHandler(ipaddress string, port int, kind string, h http.Handler) *proxyHandler {
 	return &proxyHandler{
-		handler: h,
-		timer:   time.NewTimer(proxyHandlerLifetime),
+		handler:   h,
+		timer:     time.NewTimer(proxyHandlerLifetime),
+		ipaddress: ipaddress,
+		port:      port,
+		kind:      kind,
+		healthy:   true,
 	}
 }
 
@@ -128,31 +221,134 @@ func (h *proxyHandler) extend() {
 	h.timer.Reset(proxyHandlerLifetime) // extend lifetime
 }
 
-type proxyHandlers map[int]map[string]*proxyHandler
+// available reports whether h should be considered for load-balancing: it
+// hasn't gone idle, it's passing active health checks, and any passive
+// backoff window has elapsed.
+func (h *proxyHandler) available() bool {
+	if !h.alive() {
+		return false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.healthy {
+		return false
+	}
+	return time.Now().After(h.backoffUntil)
+}
 
-func (ph proxyHandlers) Handler(port int) (http.Handler, bool) {
-	handlers := ph[port]
-	if len(handlers) == 0 {
-		return nil, false
+// markFailure records a passive failure observed on RoundTrip and applies an
+// exponential backoff before the upstream is reconsidered.
+func (h *proxyHandler) markFailure() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.failCount++
+	backoff := passiveBackoffBase * time.Duration(1<<min(h.failCount-1, 6))
+	h.backoffUntil = time.Now().Add(backoff)
+}
+
+// markSuccess clears any passive failure state after a successful RoundTrip.
+func (h *proxyHandler) markSuccess() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.failCount = 0
+	h.backoffUntil = time.Time{}
+}
+
+// setHealthy records the verdict of the active health checker.
+func (h *proxyHandler) setHealthy(healthy bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.healthy = healthy
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
 	}
-	for ipaddress, handler := range ph[port] {
-		if handler.alive() {
-			// return first (randomized by Go's map)
-			return handler.handler, true
-		} else {
+	return b
+}
+
+type proxyHandlers map[int]*portRoutes
+
+// pick selects an upstream handler for (port, urlPath) using lb, evicting
+// any that have gone idle in the process. Location matching is
+// longest-prefix-wins; clientIP is forwarded to policies that need request
+// affinity.
+func (ph proxyHandlers) pick(port int, urlPath string, clientIP string, lb LoadBalancer) *proxyHandler {
+	pr := ph[port]
+	if pr == nil {
+		return nil
+	}
+	bucket := pr.match(urlPath)
+	if bucket == nil {
+		return nil
+	}
+	candidates := make([]*proxyHandler, 0, len(bucket.handlers))
+	for ipaddress, handler := range bucket.handlers {
+		if !handler.alive() {
 			slog.Info(f("proxy handler to %s is dead", ipaddress))
-			delete(ph[port], ipaddress)
+			delete(bucket.handlers, ipaddress)
+			continue
 		}
+		if handler.available() {
+			candidates = append(candidates, handler)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
 	}
-	return nil, false
+	// Map iteration order is randomized per call; index-based policies
+	// (round-robin, ip-hash) need a stable order across calls to behave
+	// as advertised, so sort by ipaddress before handing off to lb.
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].ipaddress < candidates[j].ipaddress
+	})
+	if lb == nil {
+		return candidates[0]
+	}
+	return lb.Pick(clientIP, candidates)
 }
 
-func (ph proxyHandlers) add(port int, ipaddress string, h http.Handler) {
+// add registers an upstream for (port, location, ipaddress), defaulting an
+// empty location to the "/" fallback.
+func (ph proxyHandlers) add(port int, location string, ipaddress string, kind string, h http.Handler) {
+	if location == "" {
+		location = defaultLocation
+	}
 	if ph[port] == nil {
-		ph[port] = make(map[string]*proxyHandler)
+		ph[port] = newPortRoutes()
+	}
+	slog.Info(f("new proxy handler to %s for location %s", ipaddress, location))
+	ph[port].bucket(location).handlers[ipaddress] = newProxyHandler(ipaddress, port, kind, h)
+}
+
+// remove deregisters the upstream for (port, location, ipaddress), pruning
+// the location bucket if it is left empty.
+func (ph proxyHandlers) remove(port int, location string, ipaddress string) {
+	if location == "" {
+		location = defaultLocation
+	}
+	pr := ph[port]
+	if pr == nil {
+		return
 	}
-	slog.Info(f("new proxy handler to %s", ipaddress))
-	ph[port][ipaddress] = newProxyHandler(h)
+	b := pr.bucket(location)
+	delete(b.handlers, ipaddress)
+	pr.prune()
+}
+
+// all returns every proxyHandler currently registered, used by the health
+// checker (via ReverseProxy.handlers).
+func (ph proxyHandlers) all() []*proxyHandler {
+	var out []*proxyHandler
+	for _, pr := range ph {
+		for _, b := range pr.locations {
+			for _, h := range b.handlers {
+				out = append(out, h)
+			}
+		}
+	}
+	return out
 }
 
 type Transport struct {
@@ -160,6 +356,9 @@ type Transport struct {
 	Timeout                time.Duration
 	Subdomain              string
 	AuthCookieValidateFunc func(*http.Cookie) error
+	// Upstream, when set, is notified of RoundTrip outcomes so the owning
+	// proxyHandler can be passively marked healthy/unhealthy with backoff.
+	Upstream *proxyHandler
 }
 
 func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
@@ -177,23 +376,41 @@ func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
 		}
 	}
 	if t.Timeout == 0 {
-		return t.Transport.RoundTrip(req)
+		resp, err := t.Transport.RoundTrip(req)
+		t.markOutcome(err)
+		return resp, err
 	}
 	ctx, cancel := context.WithTimeout(req.Context(), t.Timeout)
 	defer cancel()
 	resp, err := t.Transport.RoundTrip(req.WithContext(ctx))
 	if err == nil {
+		t.markOutcome(nil)
 		return resp, nil
 	}
 	slog.Warn(f("subdomain %s %s roundtrip failed: %s", t.Subdomain, req.URL, err))
 
 	// timeout
 	if ctx.Err() == context.DeadlineExceeded {
+		t.markOutcome(err)
 		return newTimeoutResponse(t.Subdomain, req.URL.String()), nil
 	}
+	t.markOutcome(err)
 	return resp, err
 }
 
+// markOutcome passively marks the backing upstream healthy or, on error,
+// applies the exponential backoff before it is reconsidered for traffic.
+func (t *Transport) markOutcome(err error) {
+	if t.Upstream == nil {
+		return
+	}
+	if err != nil {
+		t.Upstream.markFailure()
+		return
+	}
+	t.Upstream.markSuccess()
+}
+
 func newTimeoutResponse(subdomain string, u string) *http.Response {
 	resp := new(http.Response)
 	resp.StatusCode = http.StatusGatewayTimeout
@@ -0,0 +1,27 @@
+package proxy
+
+import (
+	"net/http"
+
+	fastcgi "github.com/miseyu/feature-proxy/pkg/proxy"
+)
+
+// newUpstreamRoundTripper builds the http.RoundTripper that reaches the
+// upstream described by ctrl, selecting a FastCGI transport when
+// ctrl.Kind == "fastcgi" and, otherwise, the pooled-connection FastTransport
+// when cfg.FastProxy is enabled, falling back to the default net/http
+// transport.
+func newUpstreamRoundTripper(cfg *Config, ctrl ProxyControl, root string, splitPath string) http.RoundTripper {
+	if ctrl.Kind == upstreamKindFastCGI {
+		return &fastcgi.Transport{
+			Network:   "tcp",
+			Address:   ctrl.IPAddress,
+			Root:      root,
+			SplitPath: splitPath,
+		}
+	}
+	if cfg != nil && cfg.FastProxy {
+		return &fastcgi.FastTransport{}
+	}
+	return http.DefaultTransport
+}